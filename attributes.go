@@ -0,0 +1,109 @@
+package simpletrace
+
+import (
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// AttributeConfig is one `attribute <key> <value-template>` entry. Value is
+// resolved through Caddy's Replacer at request time, so it may reference
+// placeholders including {http.trace.*}.
+type AttributeConfig struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// sensitiveHeaders are never captured, even if explicitly named in
+// capture_request_headers/capture_response_headers, to avoid accidentally
+// shipping credentials to logs or span attributes.
+var sensitiveHeaders = map[string]bool{
+	"authorization": true,
+	"cookie":        true,
+	"set-cookie":    true,
+}
+
+// filterCapturedHeaders drops any sensitive header names from a configured
+// capture list.
+func filterCapturedHeaders(names []string) []string {
+	filtered := make([]string, 0, len(names))
+	for _, name := range names {
+		if sensitiveHeaders[strings.ToLower(name)] {
+			continue
+		}
+		filtered = append(filtered, name)
+	}
+	return filtered
+}
+
+// capturedHeaderField is a single captured header resolved to a value.
+type capturedHeaderField struct {
+	Key   string
+	Value string
+}
+
+// captureHeaders reads the named headers out of h, skipping any that
+// weren't sent.
+func captureHeaders(h http.Header, names []string) []capturedHeaderField {
+	var fields []capturedHeaderField
+	for _, name := range names {
+		if v := h.Get(name); v != "" {
+			fields = append(fields, capturedHeaderField{Key: sanitizeHeaderKey(name), Value: v})
+		}
+	}
+	return fields
+}
+
+// sanitizeHeaderKey turns a header name like "X-Forwarded-For" into the
+// lower_snake_case segment used when building attribute/log field names.
+func sanitizeHeaderKey(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "-", "_"))
+}
+
+// otelAttributeName builds the dot-separated attribute name OTel spans use,
+// e.g. {"http", "request", "header", "x_forwarded_for"} -> the equivalent
+// "http.request.header.x_forwarded_for".
+func otelAttributeName(segments []string) string {
+	return strings.Join(segments, ".")
+}
+
+// formatFieldName builds a log field name for segments (e.g.
+// {"http", "request", "header", "x_forwarded_for"}) following the naming
+// convention of the active log format, mirroring how the built-in
+// trace/span ID fields are named per format in ServeHTTP.
+func formatFieldName(format string, segments []string) string {
+	switch format {
+	case "tempo":
+		var b strings.Builder
+		for i, s := range segments {
+			if i == 0 {
+				b.WriteString(s)
+				continue
+			}
+			b.WriteString(strings.ToUpper(s[:1]) + s[1:])
+		}
+		return b.String()
+	case "datadog", "dd":
+		return "dd." + strings.Join(segments, ".")
+	case "ecs", "stackdriver", "gcp":
+		return strings.Join(segments, ".")
+	default: // "otel" or unrecognized
+		return strings.Join(segments, "_")
+	}
+}
+
+// headerSegments returns the field-name segments for a captured header.
+func headerSegments(direction, headerKey string) []string {
+	return []string{"http", direction, "header", headerKey}
+}
+
+// appendHeaderLogFields appends one zap field per captured header, named
+// according to format's convention.
+func appendHeaderLogFields(fields []zapcore.Field, format, direction string, headers []capturedHeaderField) []zapcore.Field {
+	for _, h := range headers {
+		fields = append(fields, zap.String(formatFieldName(format, headerSegments(direction, h.Key)), h.Value))
+	}
+	return fields
+}
@@ -0,0 +1,245 @@
+package simpletrace
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// ExporterConfig configures an optional OTLP span exporter. When nil,
+// SimpleTrace only enriches logs and propagates headers as before.
+type ExporterConfig struct {
+	// Protocol selects the OTLP transport: "grpc" (default) or "http"
+	Protocol string `json:"protocol,omitempty"`
+	// Endpoint is the collector address, e.g. "otel-collector:4317"
+	Endpoint string `json:"endpoint,omitempty"`
+	// Insecure disables TLS when talking to the collector
+	Insecure bool `json:"insecure,omitempty"`
+	// Headers are additional headers sent with every export request
+	Headers map[string]string `json:"headers,omitempty"`
+	// ServiceName sets the service.name resource attribute
+	ServiceName string `json:"service_name,omitempty"`
+	// Timeout bounds each export call
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// fixedIDsCtxKey is the context key used to thread the trace/span IDs that
+// ServeHTTP already computed into the OTel SDK's ID generator and sampler,
+// so the exported span carries the exact same IDs that land in the logs.
+type fixedIDsCtxKey struct{}
+
+type fixedIDs struct {
+	traceID oteltrace.TraceID
+	spanID  oteltrace.SpanID
+	sampled bool
+}
+
+// fixedIDGenerator implements sdktrace.IDGenerator by returning the IDs
+// SimpleTrace already computed from (or generated for) the traceparent
+// header, falling back to random IDs if none were supplied.
+type fixedIDGenerator struct{}
+
+func (fixedIDGenerator) NewIDs(ctx context.Context) (oteltrace.TraceID, oteltrace.SpanID) {
+	if ids, ok := ctx.Value(fixedIDsCtxKey{}).(fixedIDs); ok {
+		return ids.traceID, ids.spanID
+	}
+	return randomTraceID(), randomSpanID()
+}
+
+func (fixedIDGenerator) NewSpanID(ctx context.Context, _ oteltrace.TraceID) oteltrace.SpanID {
+	if ids, ok := ctx.Value(fixedIDsCtxKey{}).(fixedIDs); ok {
+		return ids.spanID
+	}
+	return randomSpanID()
+}
+
+func randomTraceID() oteltrace.TraceID {
+	var tid oteltrace.TraceID
+	_, _ = rand.Read(tid[:])
+	return tid
+}
+
+func randomSpanID() oteltrace.SpanID {
+	var sid oteltrace.SpanID
+	_, _ = rand.Read(sid[:])
+	return sid
+}
+
+// fixedSampler honors the sampled decision SimpleTrace already made (either
+// parsed from an inbound traceparent or rolled by its own sampling config)
+// instead of letting the OTel SDK make a second, independent decision.
+type fixedSampler struct{}
+
+func (fixedSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	result := sdktrace.SamplingResult{Tracestate: oteltrace.SpanContextFromContext(p.ParentContext).TraceState()}
+	if ids, ok := p.ParentContext.Value(fixedIDsCtxKey{}).(fixedIDs); ok && ids.sampled {
+		result.Decision = sdktrace.RecordAndSample
+	} else {
+		result.Decision = sdktrace.Drop
+	}
+	return result
+}
+
+func (fixedSampler) Description() string {
+	return "SimpleTraceFixedSampler"
+}
+
+// buildTracerProvider constructs an OTLP-backed TracerProvider from the
+// exporter config. Cleanup shuts the provider down on module teardown.
+func buildTracerProvider(ctx context.Context, cfg *ExporterConfig) (*sdktrace.TracerProvider, error) {
+	exp, err := newOTLPExporter(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("building OTLP exporter: %w", err)
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "caddy"
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("building resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithIDGenerator(fixedIDGenerator{}),
+		sdktrace.WithSampler(fixedSampler{}),
+	)
+	return tp, nil
+}
+
+func newOTLPExporter(ctx context.Context, cfg *ExporterConfig) (*otlptrace.Exporter, error) {
+	switch cfg.Protocol {
+	case "http":
+		opts := []otlptracehttp.Option{}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+		}
+		if cfg.Timeout > 0 {
+			opts = append(opts, otlptracehttp.WithTimeout(cfg.Timeout))
+		}
+		return otlptracehttp.New(ctx, opts...)
+	case "grpc", "":
+		opts := []otlptracegrpc.Option{}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlptracegrpc.WithHeaders(cfg.Headers))
+		}
+		if cfg.Timeout > 0 {
+			opts = append(opts, otlptracegrpc.WithTimeout(cfg.Timeout))
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown exporter protocol %q, expected \"grpc\" or \"http\"", cfg.Protocol)
+	}
+}
+
+// startSpan starts the span covering next.ServeHTTP when an exporter is
+// configured. Otherwise it returns the span already carried by ctx (a no-op
+// span when there is none), so callers don't need to branch on st.tracer
+// being nil.
+func (st SimpleTrace) startSpan(ctx context.Context, spanName string, ids fixedIDs) (context.Context, oteltrace.Span) {
+	if st.tracer == nil {
+		return ctx, oteltrace.SpanFromContext(ctx)
+	}
+	ctx = context.WithValue(ctx, fixedIDsCtxKey{}, ids)
+	return st.tracer.Start(ctx, spanName, oteltrace.WithSpanKind(oteltrace.SpanKindServer),
+		oteltrace.WithAttributes(attribute.String("span.origin", "caddy-simpletrace")))
+}
+
+// unmarshalExporter parses the `exporter` Caddyfile block, e.g.:
+//
+//	exporter {
+//	    protocol grpc
+//	    endpoint otel-collector:4317
+//	    insecure
+//	    header x-api-key secret
+//	    service_name my-caddy
+//	    timeout 5s
+//	}
+func unmarshalExporter(d *caddyfile.Dispenser) (*ExporterConfig, error) {
+	cfg := &ExporterConfig{Headers: make(map[string]string)}
+	for d.NextBlock(1) {
+		switch d.Val() {
+		case "protocol":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.Protocol = d.Val()
+		case "endpoint":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.Endpoint = d.Val()
+		case "insecure":
+			cfg.Insecure = true
+		case "header":
+			args := d.RemainingArgs()
+			if len(args) != 2 {
+				return nil, d.ArgErr()
+			}
+			cfg.Headers[args[0]] = args[1]
+		case "service_name":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			cfg.ServiceName = d.Val()
+		case "timeout":
+			if !d.NextArg() {
+				return nil, d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return nil, d.Errf("parsing timeout: %v", err)
+			}
+			cfg.Timeout = dur
+		default:
+			return nil, d.Errf("unknown subdirective: %s", d.Val())
+		}
+	}
+	if len(cfg.Headers) == 0 {
+		cfg.Headers = nil
+	}
+	return cfg, nil
+}
+
+// Cleanup implements caddy.CleanerUpper, shutting down the TracerProvider
+// (and flushing any buffered spans) when the module is being torn down.
+func (st *SimpleTrace) Cleanup() error {
+	if st.tracerProvider == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return st.tracerProvider.Shutdown(ctx)
+}
+
+// Interface guard
+var _ caddy.CleanerUpper = (*SimpleTrace)(nil)
@@ -0,0 +1,24 @@
+package simpletrace
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// setPlaceholders registers {http.trace.*} replacer placeholders so other
+// directives (reverse_proxy header manipulation, log format customization,
+// rewrite, respond templates, ...) can reference the computed trace context
+// without depending on ExtraLogFields.
+func setPlaceholders(r *http.Request, traceID, spanID, parentSpanID, flags string, sampled bool) {
+	repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return
+	}
+	repl.Set("http.trace.id", traceID)
+	repl.Set("http.trace.span_id", spanID)
+	repl.Set("http.trace.parent_span_id", parentSpanID)
+	repl.Set("http.trace.sampled", sampled)
+	repl.Set("http.trace.traceparent", fmt.Sprintf("00-%s-%s-%s", traceID, spanID, flags))
+}
@@ -0,0 +1,283 @@
+package simpletrace
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// extractedContext holds the trace fields a propagator pulled out of an
+// inbound request. spanID here is the upstream span that becomes this
+// request's parentSpanID.
+type extractedContext struct {
+	traceID string
+	spanID  string
+	flags   string
+	sampled bool
+	ok      bool
+}
+
+// propagator extracts trace context from an inbound request in whatever
+// format it understands, and injects the (possibly newly generated) trace
+// context into the outbound request headers for downstream services.
+// parentSpanID is the span this request inherited (empty for a freshly
+// started trace), not the new spanID being injected.
+type propagator interface {
+	name() string
+	extract(r *http.Request) extractedContext
+	inject(r *http.Request, traceID, spanID, parentSpanID, flags string, sampled bool)
+}
+
+// defaultPropagators is used when no `propagators` directive is given, to
+// preserve SimpleTrace's original W3C-only behavior.
+var defaultPropagators = []string{"tracecontext"}
+
+func newPropagator(name string) (propagator, error) {
+	switch name {
+	case "tracecontext":
+		return tracecontextPropagator{}, nil
+	case "b3":
+		return b3Propagator{}, nil
+	case "jaeger":
+		return jaegerPropagator{}, nil
+	case "xray":
+		return xrayPropagator{}, nil
+	case "baggage":
+		return baggagePropagator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown propagator %q", name)
+	}
+}
+
+// extractTrace tries each configured propagator in order and returns the
+// first one that yields a valid trace context.
+func extractTrace(propagators []propagator, r *http.Request) extractedContext {
+	for _, p := range propagators {
+		if ext := p.extract(r); ext.ok {
+			return ext
+		}
+	}
+	return extractedContext{}
+}
+
+// injectTrace writes every configured propagator's headers onto r so
+// downstream services see the new span ID in whichever formats they expect.
+func injectTrace(propagators []propagator, r *http.Request, traceID, spanID, parentSpanID, flags string, sampled bool) {
+	for _, p := range propagators {
+		p.inject(r, traceID, spanID, parentSpanID, flags, sampled)
+	}
+}
+
+// tracecontextPropagator implements W3C trace-context, SimpleTrace's
+// original (and default) propagation format.
+type tracecontextPropagator struct{}
+
+func (tracecontextPropagator) name() string { return "tracecontext" }
+
+func (tracecontextPropagator) extract(r *http.Request) extractedContext {
+	traceParent := r.Header.Get("traceparent")
+	if traceParent == "" {
+		return extractedContext{}
+	}
+	parts := strings.Split(traceParent, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return extractedContext{}
+	}
+	return extractedContext{
+		traceID: parts[1],
+		spanID:  parts[2],
+		flags:   parts[3],
+		sampled: parseSampledFlag(parts[3]),
+		ok:      true,
+	}
+}
+
+func (tracecontextPropagator) inject(r *http.Request, traceID, spanID, _, flags string, _ bool) {
+	r.Header.Set("traceparent", fmt.Sprintf("00-%s-%s-%s", traceID, spanID, flags))
+}
+
+// b3Propagator implements Zipkin's B3 propagation, both the single-header
+// and multi-header forms. On extract it accepts either; on inject it writes
+// both so it works with consumers that only understand one.
+type b3Propagator struct{}
+
+func (b3Propagator) name() string { return "b3" }
+
+func (b3Propagator) extract(r *http.Request) extractedContext {
+	if b3 := r.Header.Get("b3"); b3 != "" {
+		// traceid-spanid-sampled-parentspanid (last two fields optional)
+		parts := strings.Split(b3, "-")
+		if len(parts) >= 2 && len(parts[0]) == 32 && len(parts[1]) == 16 {
+			sampled := true
+			if len(parts) >= 3 {
+				sampled = parts[2] == "1" || parts[2] == "d"
+			}
+			return extractedContext{traceID: parts[0], spanID: parts[1], sampled: sampled, ok: true}
+		}
+		return extractedContext{}
+	}
+
+	traceID := r.Header.Get("X-B3-TraceId")
+	spanID := r.Header.Get("X-B3-SpanId")
+	if len(traceID) != 32 || len(spanID) != 16 {
+		return extractedContext{}
+	}
+	sampled := r.Header.Get("X-B3-Sampled") == "1"
+	return extractedContext{traceID: traceID, spanID: spanID, sampled: sampled, ok: true}
+}
+
+func (b3Propagator) inject(r *http.Request, traceID, spanID, parentSpanID, _ string, sampled bool) {
+	sampledFlag := "0"
+	if sampled {
+		sampledFlag = "1"
+	}
+	b3 := fmt.Sprintf("%s-%s-%s", traceID, spanID, sampledFlag)
+	if parentSpanID != "" {
+		b3 += "-" + parentSpanID
+	}
+	r.Header.Set("b3", b3)
+	r.Header.Set("X-B3-TraceId", traceID)
+	r.Header.Set("X-B3-SpanId", spanID)
+	r.Header.Set("X-B3-Sampled", sampledFlag)
+	if parentSpanID != "" {
+		r.Header.Set("X-B3-ParentSpanId", parentSpanID)
+	}
+}
+
+// jaegerPropagator implements Jaeger's uber-trace-id propagation format:
+// {trace-id}:{span-id}:{parent-span-id}:{flags}
+type jaegerPropagator struct{}
+
+func (jaegerPropagator) name() string { return "jaeger" }
+
+func (jaegerPropagator) extract(r *http.Request) extractedContext {
+	header := r.Header.Get("uber-trace-id")
+	if header == "" {
+		return extractedContext{}
+	}
+	parts := strings.Split(header, ":")
+	if len(parts) != 4 {
+		return extractedContext{}
+	}
+	traceID := strings.TrimLeft(parts[0], "0")
+	if len(traceID) < 32 {
+		traceID = strings.Repeat("0", 32-len(traceID)) + traceID
+	}
+	flagsInt, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return extractedContext{}
+	}
+	return extractedContext{
+		traceID: traceID,
+		spanID:  parts[1],
+		sampled: flagsInt&0x01 == 0x01,
+		ok:      true,
+	}
+}
+
+func (jaegerPropagator) inject(r *http.Request, traceID, spanID, parentSpanID, flags string, _ bool) {
+	flagsInt, _ := strconv.ParseInt(flags, 16, 64)
+	// The parent-span-id field is deprecated and unused by modern Jaeger
+	// backends, but must not repeat spanID — that would claim the span is
+	// its own parent. Write the real parent, or "0" for a root span.
+	if parentSpanID == "" {
+		parentSpanID = "0"
+	}
+	r.Header.Set("uber-trace-id", fmt.Sprintf("%s:%s:%s:%d", traceID, spanID, parentSpanID, flagsInt))
+}
+
+// xrayPropagator implements AWS X-Ray's header format:
+//
+//	X-Amzn-Trace-Id: Root=1-{8 hex digit timestamp}-{24 hex digit id};Parent={16 hex};Sampled={0|1}
+//
+// X-Ray's Root packs a 32-bit unix timestamp into the trace ID's first 8
+// hex characters, leaving 24 hex characters (96 bits) of randomness — the
+// same 32 hex characters as our 128-bit trace ID, just with a fixed
+// meaning for the leading chunk. We map between the two representations
+// by straight concatenation/slicing of hex characters, not by reinterpreting
+// bits, so no actual pad/truncate is needed as long as both are 32 hex
+// characters; if a propagated ID is ever short, we zero-pad on the left.
+// generateTraceID already stamps a real unix timestamp into a freshly
+// originated trace ID's first 4 bytes for exactly this reason, so Root's
+// timestamp segment is meaningful even when this node starts the trace.
+type xrayPropagator struct{}
+
+func (xrayPropagator) name() string { return "xray" }
+
+func (xrayPropagator) extract(r *http.Request) extractedContext {
+	header := r.Header.Get("X-Amzn-Trace-Id")
+	if header == "" {
+		return extractedContext{}
+	}
+	var root, parent, sampledStr string
+	for _, field := range strings.Split(header, ";") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "Root":
+			root = kv[1]
+		case "Parent":
+			parent = kv[1]
+		case "Sampled":
+			sampledStr = kv[1]
+		}
+	}
+	rootParts := strings.Split(root, "-")
+	if len(rootParts) != 3 || rootParts[0] != "1" || len(rootParts[1]) != 8 || len(rootParts[2]) != 24 {
+		return extractedContext{}
+	}
+	if len(parent) != 16 {
+		return extractedContext{}
+	}
+	return extractedContext{
+		traceID: rootParts[1] + rootParts[2],
+		spanID:  parent,
+		sampled: sampledStr == "1",
+		ok:      true,
+	}
+}
+
+func (xrayPropagator) inject(r *http.Request, traceID, spanID, _, _ string, sampled bool) {
+	if len(traceID) != 32 {
+		traceID = fmt.Sprintf("%032s", traceID)
+	}
+	sampledFlag := "0"
+	if sampled {
+		sampledFlag = "1"
+	}
+	root := fmt.Sprintf("1-%s-%s", traceID[:8], traceID[8:])
+	r.Header.Set("X-Amzn-Trace-Id", fmt.Sprintf("Root=%s;Parent=%s;Sampled=%s", root, spanID, sampledFlag))
+}
+
+// baggagePropagator passes the W3C `baggage` header through unmodified.
+// Since SimpleTrace mutates the same *http.Request it received rather than
+// building a new one, any incoming baggage header is already present on
+// the outbound request — so there's nothing to extract or inject.
+type baggagePropagator struct{}
+
+func (baggagePropagator) name() string { return "baggage" }
+
+func (baggagePropagator) extract(*http.Request) extractedContext { return extractedContext{} }
+
+func (baggagePropagator) inject(*http.Request, string, string, string, string, bool) {}
+
+// unmarshalPropagators parses the `propagators` directive, a simple list:
+//
+//	propagators tracecontext b3 jaeger xray baggage
+func unmarshalPropagators(d *caddyfile.Dispenser) ([]string, error) {
+	names := d.RemainingArgs()
+	if len(names) == 0 {
+		return nil, d.ArgErr()
+	}
+	for _, name := range names {
+		if _, err := newPropagator(name); err != nil {
+			return nil, d.Errf("%v", err)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,116 @@
+package simpletrace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	testTraceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID       = "00f067aa0ba902b7"
+	testParentSpanID = "a3ce929d0e0e4736"
+)
+
+// roundTrip injects a trace context into a request with p, then extracts it
+// back out of a fresh request carrying those same headers.
+func roundTrip(t *testing.T, p propagator, flags string, sampled bool) extractedContext {
+	t.Helper()
+	out := httptest.NewRequest(http.MethodGet, "/", nil)
+	p.inject(out, testTraceID, testSpanID, testParentSpanID, flags, sampled)
+
+	in := httptest.NewRequest(http.MethodGet, "/", nil)
+	in.Header = out.Header.Clone()
+	return p.extract(in)
+}
+
+func TestTracecontextRoundTrip(t *testing.T) {
+	ext := roundTrip(t, tracecontextPropagator{}, "01", true)
+	if !ext.ok {
+		t.Fatal("extract did not find a valid trace")
+	}
+	if ext.traceID != testTraceID || ext.spanID != testSpanID {
+		t.Errorf("got traceID=%s spanID=%s, want traceID=%s spanID=%s", ext.traceID, ext.spanID, testTraceID, testSpanID)
+	}
+	if !ext.sampled {
+		t.Error("expected sampled=true")
+	}
+}
+
+func TestB3RoundTrip(t *testing.T) {
+	ext := roundTrip(t, b3Propagator{}, "", true)
+	if !ext.ok {
+		t.Fatal("extract did not find a valid trace")
+	}
+	if ext.traceID != testTraceID || ext.spanID != testSpanID {
+		t.Errorf("got traceID=%s spanID=%s, want traceID=%s spanID=%s", ext.traceID, ext.spanID, testTraceID, testSpanID)
+	}
+	if !ext.sampled {
+		t.Error("expected sampled=true")
+	}
+}
+
+func TestJaegerRoundTrip(t *testing.T) {
+	ext := roundTrip(t, jaegerPropagator{}, "01", true)
+	if !ext.ok {
+		t.Fatal("extract did not find a valid trace")
+	}
+	if ext.traceID != testTraceID || ext.spanID != testSpanID {
+		t.Errorf("got traceID=%s spanID=%s, want traceID=%s spanID=%s", ext.traceID, ext.spanID, testTraceID, testSpanID)
+	}
+}
+
+// TestJaegerInjectDoesNotSelfParent guards against regressing the bug where
+// inject wrote the new spanID into both the span-id and parent-span-id
+// slots of uber-trace-id, making every span its own parent downstream.
+func TestJaegerInjectDoesNotSelfParent(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	jaegerPropagator{}.inject(r, testTraceID, testSpanID, testParentSpanID, "01", true)
+
+	want := testTraceID + ":" + testSpanID + ":" + testParentSpanID + ":1"
+	if got := r.Header.Get("uber-trace-id"); got != want {
+		t.Errorf("uber-trace-id = %q, want %q", got, want)
+	}
+}
+
+// TestJaegerInjectRootSpan checks that a trace with no parent gets Jaeger's
+// "0" sentinel, not an empty or duplicated span ID.
+func TestJaegerInjectRootSpan(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	jaegerPropagator{}.inject(r, testTraceID, testSpanID, "", "01", true)
+
+	want := testTraceID + ":" + testSpanID + ":0:1"
+	if got := r.Header.Get("uber-trace-id"); got != want {
+		t.Errorf("uber-trace-id = %q, want %q", got, want)
+	}
+}
+
+func TestXrayRoundTrip(t *testing.T) {
+	ext := roundTrip(t, xrayPropagator{}, "", true)
+	if !ext.ok {
+		t.Fatal("extract did not find a valid trace")
+	}
+	if ext.traceID != testTraceID {
+		t.Errorf("got traceID=%s, want %s", ext.traceID, testTraceID)
+	}
+	// xray's Parent field carries this request's own span ID, not the
+	// inherited parent, since that's what the next hop treats as its parent.
+	if ext.spanID != testSpanID {
+		t.Errorf("got spanID=%s, want %s", ext.spanID, testSpanID)
+	}
+	if !ext.sampled {
+		t.Error("expected sampled=true")
+	}
+}
+
+func TestExtractTraceTriesEachPropagatorInOrder(t *testing.T) {
+	propagators := []propagator{b3Propagator{}, tracecontextPropagator{}}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("traceparent", "00-"+testTraceID+"-"+testSpanID+"-01")
+
+	ext := extractTrace(propagators, r)
+	if !ext.ok || ext.traceID != testTraceID {
+		t.Fatalf("expected fallback to tracecontext, got %+v", ext)
+	}
+}
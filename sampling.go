@@ -0,0 +1,135 @@
+package simpletrace
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// SamplingConfig configures the root (head-based) sampling decision made
+// when SimpleTrace starts a brand new trace, and optionally how an upstream
+// sampled bit is weighed against it.
+type SamplingConfig struct {
+	// Mode is one of "always_on" (default), "always_off", "ratio", or
+	// "parent_based".
+	Mode string `json:"mode,omitempty"`
+	// Ratio is the sampling probability for mode "ratio", in [0,1].
+	Ratio float64 `json:"ratio,omitempty"`
+	// Root is the sampler consulted by mode "parent_based" when there is
+	// no upstream sampled bit to respect.
+	Root *SamplingConfig `json:"root,omitempty"`
+}
+
+// defaultSamplingConfig preserves SimpleTrace's original behavior: respect
+// an upstream sampled bit when present, otherwise sample everything.
+var defaultSamplingConfig = &SamplingConfig{
+	Mode: "parent_based",
+	Root: &SamplingConfig{Mode: "always_on"},
+}
+
+// decideSampled applies cfg to determine the sampled bit for this request.
+// traceID is used to make the ratio sampler's decision deterministic for a
+// given trace.
+func decideSampled(cfg *SamplingConfig, traceID string, ext extractedContext) bool {
+	if cfg == nil {
+		cfg = defaultSamplingConfig
+	}
+	switch cfg.Mode {
+	case "always_off":
+		return false
+	case "ratio":
+		return sampleByRatio(traceID, cfg.Ratio)
+	case "parent_based":
+		if ext.ok {
+			return ext.sampled
+		}
+		return decideSampled(cfg.Root, traceID, extractedContext{})
+	default: // "always_on" or unset
+		return true
+	}
+}
+
+// sampleByRatio makes a deterministic sampling decision from the lower 64
+// bits of the trace ID, so the same trace ID always samples the same way.
+func sampleByRatio(traceID string, ratio float64) bool {
+	raw, err := hex.DecodeString(traceID)
+	if err != nil || len(raw) != 16 {
+		return false
+	}
+	lower := binary.BigEndian.Uint64(raw[8:16])
+	return float64(lower)/float64(math.MaxUint64) < ratio
+}
+
+// applySampledBit sets the least-significant bit of a 2-hex-character trace
+// flags byte to reflect sampled, leaving any other bits untouched.
+func applySampledBit(flags string, sampled bool) string {
+	var flagByte byte
+	if len(flags) == 2 {
+		_, _ = fmt.Sscanf(flags, "%02x", &flagByte)
+	}
+	if sampled {
+		flagByte |= 0x01
+	} else {
+		flagByte &^= 0x01
+	}
+	return fmt.Sprintf("%02x", flagByte)
+}
+
+// unmarshalSampling parses the `sampling` directive, e.g.:
+//
+//	sampling always_on
+//	sampling always_off
+//	sampling ratio 0.1
+//	sampling parent_based
+//	sampling parent_based ratio 0.1
+func unmarshalSampling(d *caddyfile.Dispenser) (*SamplingConfig, error) {
+	if !d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	cfg := &SamplingConfig{Mode: d.Val()}
+	switch cfg.Mode {
+	case "always_on", "always_off":
+		// no further arguments
+	case "ratio":
+		ratio, err := parseRatioArg(d)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Ratio = ratio
+	case "parent_based":
+		if d.NextArg() {
+			root := &SamplingConfig{Mode: d.Val()}
+			if root.Mode == "ratio" {
+				ratio, err := parseRatioArg(d)
+				if err != nil {
+					return nil, err
+				}
+				root.Ratio = ratio
+			}
+			cfg.Root = root
+		} else {
+			cfg.Root = defaultSamplingConfig.Root
+		}
+	default:
+		return nil, d.Errf("unknown sampling mode: %s", cfg.Mode)
+	}
+	return cfg, nil
+}
+
+func parseRatioArg(d *caddyfile.Dispenser) (float64, error) {
+	if !d.NextArg() {
+		return 0, d.ArgErr()
+	}
+	ratio, err := strconv.ParseFloat(d.Val(), 64)
+	if err != nil {
+		return 0, d.Errf("parsing ratio: %v", err)
+	}
+	if ratio < 0 || ratio > 1 {
+		return 0, d.Errf("ratio %v out of range [0,1]", ratio)
+	}
+	return ratio, nil
+}
@@ -0,0 +1,61 @@
+package simpletrace
+
+import "testing"
+
+func TestSampleByRatioThresholds(t *testing.T) {
+	// traceID's lower 8 bytes (the last 16 hex chars) control the decision;
+	// 0x00...00 is the minimum possible value and 0xff...ff the maximum.
+	const (
+		minTraceID = "00000000000000000000000000000000"
+		maxTraceID = "ffffffffffffffffffffffffffffffff"
+	)
+
+	tests := []struct {
+		name    string
+		traceID string
+		ratio   float64
+		want    bool
+	}{
+		{"ratio zero never samples even the minimum trace ID", minTraceID, 0, false},
+		{"ratio one samples the minimum trace ID", minTraceID, 1, true},
+		// The comparison is strictly less-than, so even ratio 1 excludes the
+		// maximum possible trace ID value; this documents that edge case.
+		{"ratio one does not sample the maximum trace ID", maxTraceID, 1, false},
+		{"invalid trace ID never samples", "not-hex", 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sampleByRatio(tt.traceID, tt.ratio); got != tt.want {
+				t.Errorf("sampleByRatio(%q, %v) = %v, want %v", tt.traceID, tt.ratio, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecideSampledModes(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *SamplingConfig
+		ext  extractedContext
+		want bool
+	}{
+		{"nil config samples when there's no upstream context", nil, extractedContext{}, true},
+		{"always_off", &SamplingConfig{Mode: "always_off"}, extractedContext{}, false},
+		{"always_on", &SamplingConfig{Mode: "always_on"}, extractedContext{}, true},
+		{"parent_based respects upstream sampled=true", &SamplingConfig{Mode: "parent_based"}, extractedContext{ok: true, sampled: true}, true},
+		{"parent_based respects upstream sampled=false", &SamplingConfig{Mode: "parent_based"}, extractedContext{ok: true, sampled: false}, false},
+		{
+			"parent_based falls back to root when there's no upstream context",
+			&SamplingConfig{Mode: "parent_based", Root: &SamplingConfig{Mode: "always_off"}},
+			extractedContext{},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decideSampled(tt.cfg, testTraceID, tt.ext); got != tt.want {
+				t.Errorf("decideSampled(...) = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
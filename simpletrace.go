@@ -1,16 +1,24 @@
 package simpletrace
 
 import (
+	"bufio"
+	"context"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"net"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -27,6 +35,37 @@ type SimpleTrace struct {
 	Format string `json:"format,omitempty"`
 	// ProjectID is the GCP project ID, required for Stackdriver format
 	ProjectID string `json:"project_id,omitempty"`
+	// Exporter, if set, exports a span for each request over OTLP in
+	// addition to the default log-field enrichment.
+	Exporter *ExporterConfig `json:"exporter,omitempty"`
+	// Propagators lists, in priority order, which trace context formats to
+	// read on ingress and write on egress. Defaults to ["tracecontext"].
+	// Supported: tracecontext, b3, jaeger, xray, baggage.
+	Propagators []string `json:"propagators,omitempty"`
+	// Sampling configures the head-based sampling decision. Defaults to
+	// respecting an upstream sampled bit and sampling everything else.
+	Sampling *SamplingConfig `json:"sampling,omitempty"`
+	// SpanName is a Replacer template for the span name, resolved before
+	// next.ServeHTTP runs. Defaults to "{http.request.method} {http.request.uri.path}".
+	// Placeholders that only have a value after the handler chain runs (e.g.
+	// the response status code) will resolve to their pre-handler value.
+	SpanName string `json:"span_name,omitempty"`
+	// Attributes are additional key/value-template pairs attached to the
+	// span (when Exporter is set) or added to ExtraLogFields, using the
+	// active format's naming convention, otherwise. Like SpanName, values
+	// are resolved before next.ServeHTTP runs, so response-phase
+	// placeholders won't see their final value.
+	Attributes []AttributeConfig `json:"attributes,omitempty"`
+	// CaptureRequestHeaders lists request header names to attach to the
+	// span/log fields. Authorization and Cookie are always excluded.
+	CaptureRequestHeaders []string `json:"capture_request_headers,omitempty"`
+	// CaptureResponseHeaders lists response header names to attach to the
+	// span/log fields. Set-Cookie is always excluded.
+	CaptureResponseHeaders []string `json:"capture_response_headers,omitempty"`
+
+	tracerProvider *sdktrace.TracerProvider
+	tracer         oteltrace.Tracer
+	propagators    []propagator
 }
 
 // CaddyModule returns the Caddy module information
@@ -49,47 +88,54 @@ func (st *SimpleTrace) Provision(ctx caddy.Context) error {
 		st.ProjectID = repl.ReplaceAll("{env.GOOGLE_CLOUD_PROJECT}", "")
 	}
 
+	if st.Exporter != nil {
+		tp, err := buildTracerProvider(ctx, st.Exporter)
+		if err != nil {
+			return fmt.Errorf("provisioning OTLP exporter: %w", err)
+		}
+		st.tracerProvider = tp
+		st.tracer = tp.Tracer("github.com/jum/caddy-simpletrace")
+	}
+
+	names := st.Propagators
+	if len(names) == 0 {
+		names = defaultPropagators
+	}
+	st.propagators = make([]propagator, 0, len(names))
+	for _, name := range names {
+		p, err := newPropagator(name)
+		if err != nil {
+			return fmt.Errorf("provisioning propagators: %w", err)
+		}
+		st.propagators = append(st.propagators, p)
+	}
+
+	st.CaptureRequestHeaders = filterCapturedHeaders(st.CaptureRequestHeaders)
+	st.CaptureResponseHeaders = filterCapturedHeaders(st.CaptureResponseHeaders)
+
 	return nil
 
 }
 
 // ServeHTTP implements caddyhttp.MiddlewareHandler
 func (st SimpleTrace) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
-	traceParent := r.Header.Get("traceparent")
-
-	var traceID, spanID, parentSpanID string
-	var flags string
-	var sampled bool
-
-	if traceParent != "" {
-		// Parse existing traceparent header
-		// Format: version-trace_id-parent_span_id-trace_flags
-		parts := strings.Split(traceParent, "-")
-		if len(parts) == 4 && parts[0] == "00" {
-			traceID = parts[1]
-			parentSpanID = parts[2]
-			flags = parts[3]
-			// Parse sampled flag (least significant bit of flags byte)
-			sampled = parseSampledFlag(flags)
-			// Generate new span ID for this request
-			spanID = generateSpanID()
-		} else {
-			// Invalid format, generate new trace
-			traceID = generateTraceID()
-			spanID = generateSpanID()
-			flags = "01" // Sampled
-			sampled = true
-		}
+	var traceID, spanID, parentSpanID, flags string
+
+	// Try each configured propagator in order until one yields a valid trace.
+	ext := extractTrace(st.propagators, r)
+	if ext.ok {
+		traceID = ext.traceID
+		parentSpanID = ext.spanID
+		flags = ext.flags
+		spanID = generateSpanID()
 	} else {
-		// No traceparent header, generate new trace
+		// No valid upstream trace, start a new one
 		traceID = generateTraceID()
 		spanID = generateSpanID()
-		flags = "01" // Sampled
-		sampled = true
 	}
 
-	// Create new traceparent for downstream services
-	newTraceParent := fmt.Sprintf("00-%s-%s-%s", traceID, spanID, flags)
+	sampled := decideSampled(st.Sampling, traceID, ext)
+	flags = applySampledBit(flags, sampled)
 
 	// Build log fields based on format preference
 	var logFields []zapcore.Field
@@ -169,11 +215,144 @@ func (st SimpleTrace) ServeHTTP(w http.ResponseWriter, r *http.Request, next cad
 		extra.Add(field)
 	}
 
-	// Set traceparent header for proxied requests
-	r.Header.Set("traceparent", newTraceParent)
+	// Write every configured propagator's headers for proxied requests
+	injectTrace(st.propagators, r, traceID, spanID, parentSpanID, flags, sampled)
+
+	// Expose the trace context as {http.trace.*} replacer placeholders
+	setPlaceholders(r, traceID, spanID, parentSpanID, flags, sampled)
+
+	repl, _ := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+
+	spanNameTemplate := st.SpanName
+	if spanNameTemplate == "" {
+		spanNameTemplate = "{http.request.method} {http.request.uri.path}"
+	}
+	spanName := spanNameTemplate
+	if repl != nil {
+		spanName = repl.ReplaceAll(spanNameTemplate, "")
+	}
+
+	// spanName and resolvedAttrs are resolved here, before next.ServeHTTP
+	// runs, unlike the status code and response headers captured below. Any
+	// span_name/attribute template referencing response-phase placeholders
+	// (e.g. status code, upstream timing) will see their pre-handler zero
+	// value, not the real one.
+	resolvedAttrs := make([]AttributeConfig, len(st.Attributes))
+	for i, a := range st.Attributes {
+		value := a.Value
+		if repl != nil {
+			value = repl.ReplaceAll(value, "")
+		}
+		resolvedAttrs[i] = AttributeConfig{Key: a.Key, Value: value}
+	}
+
+	requestHeaders := captureHeaders(r.Header, st.CaptureRequestHeaders)
+
+	var span oteltrace.Span
+	if st.tracer != nil {
+		tid, errTID := oteltrace.TraceIDFromHex(traceID)
+		sid, errSID := oteltrace.SpanIDFromHex(spanID)
+		if errTID == nil && errSID == nil {
+			var ctx context.Context
+			ctx, span = st.startSpan(r.Context(), spanName, fixedIDs{traceID: tid, spanID: sid, sampled: sampled})
+			r = r.WithContext(ctx)
+			defer span.End()
+		}
+	}
+
+	// Only wrap the response writer when something actually needs the
+	// captured status code or response headers. statusCapturingResponseWriter
+	// forwards Flush, Hijack and Push to the writer it wraps, but exposes no
+	// other optional interface of it (it has no Unwrap method, for one), so
+	// handlers such as reverse_proxy get the unwrapped writer whenever
+	// nothing needs capturing.
+	needsWrapper := st.tracer != nil || len(st.CaptureResponseHeaders) > 0
+	var rw http.ResponseWriter = w
+	var sw *statusCapturingResponseWriter
+	if needsWrapper {
+		sw = &statusCapturingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		rw = sw
+	}
+	err := next.ServeHTTP(rw, r)
+
+	statusCode := http.StatusOK
+	var responseHeaders []capturedHeaderField
+	if sw != nil {
+		statusCode = sw.statusCode
+		responseHeaders = captureHeaders(sw.Header(), st.CaptureResponseHeaders)
+	}
+
+	if span != nil {
+		attrs := []attribute.KeyValue{
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.HTTPRoute(r.URL.Path),
+			semconv.ServerAddress(r.Host),
+			semconv.HTTPResponseStatusCode(statusCode),
+		}
+		for _, a := range resolvedAttrs {
+			attrs = append(attrs, attribute.String(a.Key, a.Value))
+		}
+		for _, h := range requestHeaders {
+			attrs = append(attrs, attribute.String(otelAttributeName(headerSegments("request", h.Key)), h.Value))
+		}
+		for _, h := range responseHeaders {
+			attrs = append(attrs, attribute.String(otelAttributeName(headerSegments("response", h.Key)), h.Value))
+		}
+		span.SetAttributes(attrs...)
+	} else {
+		var extraFields []zapcore.Field
+		for _, a := range resolvedAttrs {
+			extraFields = append(extraFields, zap.String(formatFieldName(format, []string{a.Key}), a.Value))
+		}
+		extraFields = appendHeaderLogFields(extraFields, format, "request", requestHeaders)
+		extraFields = appendHeaderLogFields(extraFields, format, "response", responseHeaders)
+		for _, field := range extraFields {
+			extra.Add(field)
+		}
+	}
+
+	return err
+}
+
+// statusCapturingResponseWriter wraps http.ResponseWriter to remember the
+// status code written, so it can be recorded as a span attribute.
+type statusCapturingResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (w *statusCapturingResponseWriter) WriteHeader(code int) {
+	w.statusCode = code
+	w.ResponseWriter.WriteHeader(code)
+}
 
-	return next.ServeHTTP(w, r)
+// Flush forwards to the underlying ResponseWriter's http.Flusher, if it
+// implements one, so streaming responses (e.g. SSE) proxied through this
+// handler still flush as written.
+func (w *statusCapturingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
 
+// Hijack forwards to the underlying ResponseWriter's http.Hijacker, if it
+// implements one, so protocol upgrades (e.g. WebSockets) proxied through
+// this handler still work.
+func (w *statusCapturingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("underlying ResponseWriter does not implement http.Hijacker")
+	}
+	return hj.Hijack()
+}
+
+// Push forwards to the underlying ResponseWriter's http.Pusher, if it
+// implements one.
+func (w *statusCapturingResponseWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
 }
 
 // UnmarshalCaddyfile implements caddyfile.Unmarshaler
@@ -191,6 +370,47 @@ func (st *SimpleTrace) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return d.ArgErr()
 				}
 				st.ProjectID = d.Val()
+			case "exporter":
+				cfg, err := unmarshalExporter(d)
+				if err != nil {
+					return err
+				}
+				st.Exporter = cfg
+			case "propagators":
+				names, err := unmarshalPropagators(d)
+				if err != nil {
+					return err
+				}
+				st.Propagators = names
+			case "sampling":
+				cfg, err := unmarshalSampling(d)
+				if err != nil {
+					return err
+				}
+				st.Sampling = cfg
+			case "span_name":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				st.SpanName = d.Val()
+			case "attribute":
+				args := d.RemainingArgs()
+				if len(args) != 2 {
+					return d.ArgErr()
+				}
+				st.Attributes = append(st.Attributes, AttributeConfig{Key: args[0], Value: args[1]})
+			case "capture_request_headers":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				st.CaptureRequestHeaders = append(st.CaptureRequestHeaders, args...)
+			case "capture_response_headers":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				st.CaptureResponseHeaders = append(st.CaptureResponseHeaders, args...)
 			default:
 				return d.Errf("unknown subdirective: %s", d.Val())
 			}
@@ -222,13 +442,19 @@ func parseSampledFlag(flags string) bool {
 	return (flagByte & 0x01) == 0x01
 }
 
-// generateTraceID generates a 32-character hex trace ID (16 bytes)
+// generateTraceID generates a 32-character hex trace ID (16 bytes). The
+// first 4 bytes are the current unix timestamp, matching AWS X-Ray's trace
+// ID convention, so a freshly originated trace still has a valid-looking
+// timestamp segment when propagated as X-Ray's Root; the remaining 12
+// bytes are random. This is harmless to every other propagation format,
+// which treat the trace ID as an opaque 128-bit value.
 func generateTraceID() string {
 	b := make([]byte, 16)
-	_, err := rand.Read(b)
+	binary.BigEndian.PutUint32(b[:4], uint32(time.Now().Unix()))
+	_, err := rand.Read(b[4:])
 	if err != nil {
 		// Fallback to less random but functional approach
-		return fmt.Sprintf("%032x", 0)
+		return fmt.Sprintf("%08x%024x", uint32(time.Now().Unix()), 0)
 	}
 	return hex.EncodeToString(b)
 }